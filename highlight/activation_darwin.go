@@ -0,0 +1,48 @@
+//go:build darwin
+
+package main
+
+/*
+#include <launch.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// listenActivated returns a listener inherited from launchd via
+// launch_activate_socket, or nil if this process was not activated that way.
+func listenActivated() (net.Listener, error) {
+	name := C.CString("Listener")
+	defer C.free(unsafe.Pointer(name))
+	var fds *C.int
+	var n C.size_t
+	if ret := C.launch_activate_socket(name, &fds, &n); ret != 0 {
+		return nil, nil
+	}
+	defer C.free(unsafe.Pointer(fds))
+	if n < 1 {
+		return nil, fmt.Errorf("launch_activate_socket: no file descriptors for %q", "Listener")
+	}
+	fdSlice := unsafe.Slice(fds, n)
+	if n > 1 {
+		// Only a single socket name/fd is configured ("Listener"), so extra
+		// descriptors are unexpected; close them rather than leak them.
+		for _, fd := range fdSlice[1:] {
+			syscall.Close(int(fd))
+		}
+	}
+	f := os.NewFile(uintptr(fdSlice[0]), "launchd socket")
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return l, nil
+}
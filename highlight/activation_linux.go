@@ -0,0 +1,31 @@
+//go:build linux
+
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenActivated returns a listener inherited from the init system via
+// systemd-style socket activation (the LISTEN_FDS/LISTEN_PID protocol), or
+// nil if this process was not activated that way.
+func listenActivated() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds < 1 {
+		return nil, nil
+	}
+	// Activated file descriptors start at 3, right after stdin/stdout/stderr.
+	f := os.NewFile(3, "LISTEN_FD_3")
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return l, nil
+}
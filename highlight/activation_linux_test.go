@@ -0,0 +1,58 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+// These cases cover listenActivated's env-parsing fallbacks. They stop short
+// of a successful activation, which would require a real listening socket
+// already open on fd 3.
+
+func TestListenActivatedNoPID(t *testing.T) {
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "1")
+	l, err := listenActivated()
+	if l != nil || err != nil {
+		t.Errorf("listenActivated() = %v, %v; want nil, nil", l, err)
+	}
+}
+
+func TestListenActivatedWrongPID(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	l, err := listenActivated()
+	if l != nil || err != nil {
+		t.Errorf("listenActivated() = %v, %v; want nil, nil", l, err)
+	}
+}
+
+func TestListenActivatedNoFDs(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "")
+	l, err := listenActivated()
+	if l != nil || err != nil {
+		t.Errorf("listenActivated() = %v, %v; want nil, nil", l, err)
+	}
+}
+
+func TestListenActivatedInvalidFDs(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "nope")
+	l, err := listenActivated()
+	if l != nil || err != nil {
+		t.Errorf("listenActivated() = %v, %v; want nil, nil", l, err)
+	}
+}
+
+func TestListenActivatedZeroFDs(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "0")
+	l, err := listenActivated()
+	if l != nil || err != nil {
+		t.Errorf("listenActivated() = %v, %v; want nil, nil", l, err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package main
+
+import "net"
+
+// listenActivated always returns nil: socket activation is not supported on
+// this platform.
+func listenActivated() (net.Listener, error) {
+	return nil, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// cache is the response cache consulted by handle before (and filled after)
+// highlighting a request. It is nil when caching is disabled.
+var cache *responseCache
+
+// responseCache is an LRU cache of highlighted HTML responses, keyed by
+// request header (language plus options) and a hash of the code. It is safe
+// for concurrent use by the goroutines serve spawns per connection.
+type responseCache struct {
+	maxEntries int
+	maxBytes   int
+	ttl        time.Duration // 0 means entries never expire
+
+	mu     sync.Mutex
+	bytes  int
+	hits   int64
+	misses int64
+	ll     *list.List
+	items  map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key       string
+	html      []byte
+	expiresAt time.Time // zero value means no expiry
+}
+
+// newResponseCache creates a cache that evicts least-recently-used entries
+// once it holds more than maxEntries entries or maxBytes bytes of HTML,
+// whichever comes first, and that treats entries older than ttl as misses.
+// A limit (or ttl) of 0 means unlimited (or no expiry).
+func newResponseCache(maxEntries, maxBytes int, ttl time.Duration) *responseCache {
+	return &responseCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// cacheKey derives a responseCache key from a request's header (LANGUAGE
+// plus any "?OPTS") and its code.
+func cacheKey(header, code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return header + "\x00" + string(sum[:])
+}
+
+func (c *responseCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if c.ttl > 0 && time.Now().After(entry.expiresAt) {
+		c.removeLocked(e)
+		c.misses++
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	c.hits++
+	return entry.html, true
+}
+
+func (c *responseCache) put(key string, html []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var expiresAt time.Time
+	if c.ttl > 0 {
+		expiresAt = time.Now().Add(c.ttl)
+	}
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		old := e.Value.(*cacheEntry)
+		c.bytes += len(html) - len(old.html)
+		old.html, old.expiresAt = html, expiresAt
+	} else {
+		c.items[key] = c.ll.PushFront(&cacheEntry{key: key, html: html, expiresAt: expiresAt})
+		c.bytes += len(html)
+	}
+	for (c.maxEntries > 0 && c.ll.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		e := c.ll.Back()
+		if e == nil {
+			break
+		}
+		c.removeLocked(e)
+	}
+}
+
+// removeLocked removes e from the cache. c.mu must already be held.
+func (c *responseCache) removeLocked(e *list.Element) {
+	c.ll.Remove(e)
+	entry := e.Value.(*cacheEntry)
+	delete(c.items, entry.key)
+	c.bytes -= len(entry.html)
+}
+
+// flush empties the cache without resetting its hit/miss counters.
+func (c *responseCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.bytes = 0
+}
+
+// cacheStats is the JSON response to a "stats:" request.
+type cacheStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+	Bytes   int   `json:"bytes"`
+}
+
+func (c *responseCache) stats() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cacheStats{Hits: c.hits, Misses: c.misses, Entries: c.ll.Len(), Bytes: c.bytes}
+}
+
+// writeStats writes c's stats as JSON to w, or "{}" if caching is disabled.
+func writeStats(w io.Writer, c *responseCache) error {
+	if c == nil {
+		_, err := io.WriteString(w, "{}")
+		return err
+	}
+	data, err := json.Marshal(c.stats())
+	if err != nil {
+		return fmt.Errorf("marshaling cache stats: %w", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResponseCacheGetPut(t *testing.T) {
+	c := newResponseCache(0, 0, 0)
+	if _, ok := c.get("missing"); ok {
+		t.Error("get(missing): want miss")
+	}
+	c.put("k", []byte("html"))
+	got, ok := c.get("k")
+	if !ok || string(got) != "html" {
+		t.Errorf("get(k) = %q, %v; want %q, true", got, ok, "html")
+	}
+	stats := c.stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 || stats.Bytes != len("html") {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestResponseCacheEvictsByEntryCount(t *testing.T) {
+	c := newResponseCache(2, 0, 0)
+	c.put("a", []byte("x"))
+	c.put("b", []byte("x"))
+	c.put("c", []byte("x")) // evicts "a", the least recently used
+	if _, ok := c.get("a"); ok {
+		t.Error("get(a): want evicted")
+	}
+	if _, ok := c.get("b"); !ok {
+		t.Error("get(b): want present")
+	}
+	if _, ok := c.get("c"); !ok {
+		t.Error("get(c): want present")
+	}
+}
+
+func TestResponseCacheEvictsByByteSize(t *testing.T) {
+	c := newResponseCache(0, 5, 0)
+	c.put("a", []byte("abc"))
+	c.put("b", []byte("abc")) // total would be 6 bytes, over the 5 byte cap
+	if _, ok := c.get("a"); ok {
+		t.Error("get(a): want evicted once over the byte cap")
+	}
+	if _, ok := c.get("b"); !ok {
+		t.Error("get(b): want present")
+	}
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	c := newResponseCache(0, 0, time.Millisecond)
+	c.put("k", []byte("html"))
+	if _, ok := c.get("k"); !ok {
+		t.Fatal("get(k) immediately after put: want hit")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := c.get("k"); ok {
+		t.Error("get(k) after ttl elapsed: want miss")
+	}
+}
+
+func TestResponseCacheFlush(t *testing.T) {
+	c := newResponseCache(0, 0, 0)
+	c.put("k", []byte("html"))
+	c.flush()
+	if _, ok := c.get("k"); ok {
+		t.Error("get(k) after flush: want miss")
+	}
+	if stats := c.stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("stats after flush = %+v", stats)
+	}
+}
+
+// TestResponseCacheConcurrent exercises get/put/evict from many goroutines at
+// once, mirroring the concurrent go serve(conn) callers in run(). Run with
+// -race to catch data races.
+func TestResponseCacheConcurrent(t *testing.T) {
+	c := newResponseCache(8, 0, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("k%d", i%16)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.put(key, []byte("html"))
+			c.get(key)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/alecthomas/chroma"
+)
+
+// classifiers is the registry used to classify tokens into CSS classes. It
+// is initialized from the built-in default config, or from -config, before
+// run starts serving requests.
+var classifiers *registry
+
+//go:embed default_config.json
+var defaultConfig []byte
+
+// Config is the on-disk format for -config: a set of named languages, each a
+// list of rules plus an optional base language to inherit rules from.
+type Config struct {
+	Languages map[string]LangConfig `json:"languages"`
+}
+
+// LangConfig is one language's entry in a Config.
+type LangConfig struct {
+	Inherits string       `json:"inherits"`
+	Rules    []RuleConfig `json:"rules"`
+}
+
+// RuleConfig maps a chroma token, optionally qualified by its neighbors'
+// values, to a CSS class. Type and Category are mutually exclusive; Type
+// matches a token's exact chroma.TokenType, Category matches t.InCategory.
+type RuleConfig struct {
+	Type     string `json:"type,omitempty"`
+	Category string `json:"category,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Prev     string `json:"prev,omitempty"`
+	Next     string `json:"next,omitempty"`
+	Class    string `json:"class"`
+}
+
+// tokenTypesByName maps the chroma.TokenType names usable in a RuleConfig's
+// Type or Category field to their values.
+var tokenTypesByName = map[string]chroma.TokenType{
+	"Keyword":              chroma.Keyword,
+	"KeywordType":          chroma.KeywordType,
+	"KeywordPseudo":        chroma.KeywordPseudo,
+	"NameBuiltin":          chroma.NameBuiltin,
+	"NameConstant":         chroma.NameConstant,
+	"NameVariableInstance": chroma.NameVariableInstance,
+	"LiteralStringSymbol":  chroma.LiteralStringSymbol,
+	"Comment":              chroma.Comment,
+	"Literal":              chroma.Literal,
+}
+
+// rule is a compiled RuleConfig.
+type rule struct {
+	match func(prev, t, next chroma.Token) bool
+	class string
+}
+
+func compileRule(rc RuleConfig) (rule, error) {
+	var tt, cat chroma.TokenType
+	var hasType, hasCat bool
+	if rc.Type != "" {
+		if tt, hasType = tokenTypesByName[rc.Type]; !hasType {
+			return rule{}, fmt.Errorf("unknown token type %q", rc.Type)
+		}
+	}
+	if rc.Category != "" {
+		if cat, hasCat = tokenTypesByName[rc.Category]; !hasCat {
+			return rule{}, fmt.Errorf("unknown token category %q", rc.Category)
+		}
+	}
+	return rule{
+		class: rc.Class,
+		match: func(prev, t, next chroma.Token) bool {
+			if hasType && t.Type != tt {
+				return false
+			}
+			if hasCat && !t.Type.InCategory(cat) {
+				return false
+			}
+			if rc.Value != "" && t.Value != rc.Value {
+				return false
+			}
+			if rc.Prev != "" && prev.Value != rc.Prev {
+				return false
+			}
+			if rc.Next != "" && next.Value != rc.Next {
+				return false
+			}
+			return true
+		},
+	}, nil
+}
+
+func compileRules(rcs []RuleConfig) ([]rule, error) {
+	rules := make([]rule, len(rcs))
+	for i, rc := range rcs {
+		r, err := compileRule(rc)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		rules[i] = r
+	}
+	return rules, nil
+}
+
+// registry compiles a Config's languages into classifiers on demand,
+// resolving "inherits" and memoizing the result. It is safe for concurrent
+// use by the goroutines serve spawns per connection.
+type registry struct {
+	cfg Config
+
+	mu    sync.Mutex
+	cache map[string]classifier
+}
+
+func newRegistry(cfg Config) *registry {
+	return &registry{cfg: cfg, cache: make(map[string]classifier)}
+}
+
+// classifier returns the classifier for lang, falling back to the "default"
+// language if lang has no entry of its own.
+func (r *registry) classifier(lang string) (classifier, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.cache[lang]; ok {
+		return c, nil
+	}
+	lc, ok := r.cfg.Languages[lang]
+	if !ok {
+		lc = r.cfg.Languages["default"]
+	}
+	rules, err := compileRules(lc.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", lang, err)
+	}
+	if lc.Inherits != "" {
+		base, ok := r.cfg.Languages[lc.Inherits]
+		if !ok {
+			return nil, fmt.Errorf("%s: inherits unknown language %q", lang, lc.Inherits)
+		}
+		baseRules, err := compileRules(base.Rules)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", lc.Inherits, err)
+		}
+		rules = append(rules, baseRules...)
+	}
+	c := func(prev, t, next chroma.Token) string {
+		for _, rl := range rules {
+			if rl.match(prev, t, next) {
+				return rl.class
+			}
+		}
+		return ""
+	}
+	r.cache[lang] = c
+	return c, nil
+}
+
+// loadRegistry loads a Config from path, or from the built-in default config
+// if path is empty, and returns a registry for it.
+func loadRegistry(path string) (*registry, error) {
+	data := defaultConfig
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing config: %w", err)
+	}
+	return newRegistry(cfg), nil
+}
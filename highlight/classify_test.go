@@ -0,0 +1,158 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/alecthomas/chroma"
+)
+
+func testConfig() Config {
+	return Config{Languages: map[string]LangConfig{
+		"default": {Rules: []RuleConfig{
+			{Type: "KeywordType", Class: "fu"},
+			{Category: "Comment", Class: "at"},
+		}},
+		"ruby": {
+			Inherits: "default",
+			Rules: []RuleConfig{
+				{Type: "NameConstant", Class: ""},
+				{Type: "NameBuiltin", Value: "test", Class: "kw"},
+			},
+		},
+	}}
+}
+
+func TestRegistryInherits(t *testing.T) {
+	r := newRegistry(testConfig())
+
+	classify, err := r.classifier("ruby")
+	if err != nil {
+		t.Fatalf("classifier(ruby): %v", err)
+	}
+
+	// Ruby's own value-qualified rule applies (default has no NameBuiltin rule).
+	if got := classify(chroma.Token{}, chroma.Token{Type: chroma.NameBuiltin, Value: "test"}, chroma.Token{}); got != "kw" {
+		t.Errorf("NameBuiltin(test) = %q, want %q", got, "kw")
+	}
+	// Falls through to the inherited "default" rule when ruby has none.
+	if got := classify(chroma.Token{}, chroma.Token{Type: chroma.KeywordType}, chroma.Token{}); got != "fu" {
+		t.Errorf("KeywordType = %q, want %q", got, "fu")
+	}
+	// No rule matches at all.
+	if got := classify(chroma.Token{}, chroma.Token{Type: chroma.NameBuiltin, Value: "other"}, chroma.Token{}); got != "" {
+		t.Errorf("NameBuiltin(other) = %q, want empty", got)
+	}
+}
+
+func TestRegistryUnknownLanguageFallsBackToDefault(t *testing.T) {
+	r := newRegistry(testConfig())
+	classify, err := r.classifier("python")
+	if err != nil {
+		t.Fatalf("classifier(python): %v", err)
+	}
+	if got := classify(chroma.Token{}, chroma.Token{Type: chroma.KeywordType}, chroma.Token{}); got != "fu" {
+		t.Errorf("KeywordType = %q, want %q", got, "fu")
+	}
+}
+
+func TestRegistryUnknownInherits(t *testing.T) {
+	cfg := Config{Languages: map[string]LangConfig{
+		"broken": {Inherits: "missing"},
+	}}
+	r := newRegistry(cfg)
+	if _, err := r.classifier("broken"); err == nil {
+		t.Error("classifier(broken): want error for unknown inherits target")
+	}
+}
+
+// TestRegistryDefaultConfig loads the real shipped default_config.json (the
+// same way main does via loadRegistry("")) and checks it against
+// representative tokens for "default" and "ruby", pinning down the behavior
+// the old tokenClass/rubyTokenClass functions had before the data-driven
+// registry replaced them. A typo'd type name or misplaced rule in
+// default_config.json would otherwise only surface as a runtime error (or
+// silently wrong CSS class) the first time that language is requested.
+func TestRegistryDefaultConfig(t *testing.T) {
+	r, err := loadRegistry("")
+	if err != nil {
+		t.Fatalf("loadRegistry(\"\"): %v", err)
+	}
+
+	def, err := r.classifier("default")
+	if err != nil {
+		t.Fatalf("classifier(default): %v", err)
+	}
+	for _, tt := range []struct {
+		name string
+		tok  chroma.Token
+		want string
+	}{
+		{"KeywordType", chroma.Token{Type: chroma.KeywordType}, "fu"},
+		{"NameBuiltin", chroma.Token{Type: chroma.NameBuiltin}, "fu"},
+		{"KeywordPseudo", chroma.Token{Type: chroma.KeywordPseudo}, "cn"},
+		{"NameConstant", chroma.Token{Type: chroma.NameConstant}, "cn"},
+		{"Comment", chroma.Token{Type: chroma.CommentSingle}, "at"},
+		{"Keyword", chroma.Token{Type: chroma.Keyword}, "kw"},
+		{"LiteralNumber", chroma.Token{Type: chroma.LiteralNumberInteger}, "cn"},
+		{"Text", chroma.Token{Type: chroma.Text}, ""},
+	} {
+		if got := def(chroma.Token{}, tt.tok, chroma.Token{}); got != tt.want {
+			t.Errorf("default: %s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	ruby, err := r.classifier("ruby")
+	if err != nil {
+		t.Fatalf("classifier(ruby): %v", err)
+	}
+	for _, tt := range []struct {
+		name string
+		prev chroma.Token
+		tok  chroma.Token
+		next chroma.Token
+		want string
+	}{
+		// Ruby overrides NameConstant to not be classified, unlike default.
+		{"NameConstant", chroma.Token{}, chroma.Token{Type: chroma.NameConstant}, chroma.Token{}, ""},
+		{"NameVariableInstance", chroma.Token{}, chroma.Token{Type: chroma.NameVariableInstance}, chroma.Token{}, "fu"},
+		{"NameBuiltin(test)", chroma.Token{}, chroma.Token{Type: chroma.NameBuiltin, Value: "test"}, chroma.Token{}, "kw"},
+		{"NameBuiltin(other)", chroma.Token{}, chroma.Token{Type: chroma.NameBuiltin, Value: "puts"}, chroma.Token{}, ""},
+		// A symbol immediately followed by ":" (a hash-literal key, e.g.
+		// `foo:`) is not classified; chroma lexes the name and its trailing
+		// colon as separate tokens.
+		{"Symbol as hash key", chroma.Token{}, chroma.Token{Type: chroma.LiteralStringSymbol, Value: "foo"}, chroma.Token{Value: ":"}, ""},
+		// Any other symbol (e.g. `:foo`) falls through to default's Literal
+		// category rule, since ruby has no unconditional symbol rule.
+		{"Symbol literal", chroma.Token{}, chroma.Token{Type: chroma.LiteralStringSymbol, Value: "foo"}, chroma.Token{Value: "\n"}, "cn"},
+		// Falls through to the inherited "default" rules.
+		{"KeywordType", chroma.Token{}, chroma.Token{Type: chroma.KeywordType}, chroma.Token{}, "fu"},
+		{"Comment", chroma.Token{}, chroma.Token{Type: chroma.CommentSingle}, chroma.Token{}, "at"},
+		{"Keyword", chroma.Token{}, chroma.Token{Type: chroma.Keyword}, chroma.Token{}, "kw"},
+		{"KeywordPseudo", chroma.Token{}, chroma.Token{Type: chroma.KeywordPseudo}, chroma.Token{}, "cn"},
+	} {
+		if got := ruby(tt.prev, tt.tok, tt.next); got != tt.want {
+			t.Errorf("ruby: %s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestRegistryConcurrentAccess exercises the classifier cache from many
+// goroutines at once, mirroring the concurrent go serve(conn) callers in
+// run(). Run with -race to catch data races on the cache map.
+func TestRegistryConcurrentAccess(t *testing.T) {
+	r := newRegistry(testConfig())
+	langs := []string{"ruby", "default", "python", "ruby", "default"}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		lang := langs[i%len(langs)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.classifier(lang); err != nil {
+				t.Errorf("classifier(%s): %v", lang, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
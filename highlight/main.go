@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -11,6 +12,7 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/alecthomas/chroma"
 	"github.com/alecthomas/chroma/lexers"
@@ -19,66 +21,122 @@ import (
 
 func printUsage(w io.Writer) {
 	fmt.Fprintf(w,
-		`Usage: %s SOCKET [FIFO]
+		`Usage: %s [-print] [SOCKET [FIFO]]
 
 Runs a server that highlights code using https://github.com/alecthomas/chroma
 
 Arguments:
     SOCKET  Socket path. The server creates a stream-oriented Unix domain socket
-            here to listen on. It exits automatically if SOCKET is removed.
+            here to listen on. It exits automatically if SOCKET is removed. If
+            omitted, the server expects to be socket-activated by the init
+            system instead (systemd's LISTEN_FDS/LISTEN_PID protocol on Linux,
+            launchd's launch_activate_socket on macOS).
     FIFO    Synchronization file. If provided, the sever signals FIFO (opens it
             for writing and closes it) when ready to serve requests on SOCKET.
 
+Flags:
+    -print  Instead of signaling FIFO, print shell-evalable lines to stdout
+            (in the style of ssh-agent) once SOCKET is ready, e.g. for use as
+            eval "$(%s -print /tmp/foo)". Requires SOCKET and forbids FIFO.
+    -config FILE
+            Load the classifier/theme config from FILE instead of using the
+            built-in default. See classify.go for the config format.
+    -cache-size N
+            Cache at most N highlighted responses (0 = unlimited). Default 1024.
+    -cache-bytes N
+            Cache at most N bytes of highlighted HTML (0 = unlimited). Default 64 MiB.
+    -cache-ttl DURATION
+            Expire cached responses older than DURATION, e.g. "1h" (0 = never
+            expire). Default 1h.
+
 Request format:
-    LANGUAGE ":" CODE "\0"
+    (LANGUAGE ["?" OPTS] ":" CODE | "stats:" | "flush:") "\0"
+
+    OPTS is a query-string-ish "k=v&k=v" list of rendering options:
+        ln         show line numbers
+        start=N    start numbering at N instead of 1
+        hl=LIST    highlight the given lines, e.g. "hl=2,4-6"
+        ctx=N      elide lines more than N away from any hl line
+        diff       CODE's lines start with a '+'/'-'/' ' diff marker,
+                   rendered as a gutter instead of code
+
+    "stats:" returns the response cache's hit/miss/entry/byte counts as JSON.
+    "flush:" empties the response cache.
 
 Response format:
     (HTML_OUTPUT | "error:" ERROR_MESSAGE) "\0"
 `,
-		os.Args[0])
+		os.Args[0], os.Args[0])
 }
 
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix(os.Args[0] + ": ")
-	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+	if len(os.Args) >= 2 && (os.Args[1] == "-h" || os.Args[1] == "--help") {
 		printUsage(os.Stdout)
 		return
 	}
-	if len(os.Args) > 3 {
+	print := flag.Bool("print", false, "print shell-evalable variables to stdout instead of signaling FIFO")
+	config := flag.String("config", "", "path to a classifier/theme config file, overriding the built-in default")
+	cacheSize := flag.Int("cache-size", 1024, "max number of highlighted responses to cache (0 = unlimited)")
+	cacheBytes := flag.Int("cache-bytes", 64<<20, "max total bytes of HTML to cache (0 = unlimited)")
+	cacheTTL := flag.Duration("cache-ttl", time.Hour, "expire cached responses older than this (0 = never)")
+	flag.Usage = func() { printUsage(os.Stderr) }
+	flag.Parse()
+	args := flag.Args()
+	if len(args) > 2 {
 		printUsage(os.Stderr)
 		os.Exit(1)
 	}
-	socket := os.Args[1]
-	var fifo string
-	if len(os.Args) == 3 {
-		fifo = os.Args[2]
+	var socket, fifo string
+	if len(args) >= 1 {
+		socket = args[0]
+	}
+	if len(args) == 2 {
+		fifo = args[1]
+	}
+	if *print && (socket == "" || fifo != "") {
+		fmt.Fprintln(os.Stderr, "-print requires SOCKET and forbids FIFO")
+		os.Exit(1)
+	}
+	var err error
+	if classifiers, err = loadRegistry(*config); err != nil {
+		log.Fatal(err)
 	}
-	if err := run(socket, fifo); err != nil {
+	cache = newResponseCache(*cacheSize, *cacheBytes, *cacheTTL)
+	if err := run(socket, fifo, *print); err != nil {
 		log.Fatal(err)
 	}
 }
 
-// run runs the server on socket, signaling fifo once it is ready.
-func run(socket, fifo string) error {
-	if _, err := os.Stat(socket); err == nil {
-		return fmt.Errorf("%s: socket already exists", socket)
-	}
-	onSignals(func() { os.Remove(socket) },
-		os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
-	l, err := net.Listen("unix", socket)
+// run runs the server on socket, signaling fifo (or printing bootstrap
+// variables, if print is set) once it is ready. If socket is empty, run
+// expects to inherit an already-bound listener via socket activation.
+func run(socket, fifo string, print bool) error {
+	l, owned, err := acquireListener(socket)
 	if err != nil {
 		return err
 	}
 	defer l.Close()
-	defer os.Remove(socket)
-	log.Printf("listening on %s", socket)
-	socketRemoved := make(chan notify.EventInfo, 1)
-	if err := notify.Watch(socket, socketRemoved, notify.Remove); err != nil {
-		return err
+
+	var socketRemoved chan notify.EventInfo
+	if owned {
+		onSignals(func() { os.Remove(socket) },
+			os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		defer os.Remove(socket)
+		socketRemoved = make(chan notify.EventInfo, 1)
+		if err := notify.Watch(socket, socketRemoved, notify.Remove); err != nil {
+			return err
+		}
+		defer notify.Stop(socketRemoved)
 	}
-	defer notify.Stop(socketRemoved)
-	if fifo != "" {
+	log.Printf("listening on %s", l.Addr())
+
+	switch {
+	case print:
+		fmt.Printf("BLOG_HIGHLIGHT_SOCK=%s; export BLOG_HIGHLIGHT_SOCK;\n", socket)
+		fmt.Printf("echo Highlighter pid %d;\n", os.Getpid())
+	case fifo != "":
 		log.Printf("signaling %s", fifo)
 		f, err := os.OpenFile(fifo, os.O_WRONLY, 0)
 		if err != nil {
@@ -86,6 +144,7 @@ func run(socket, fifo string) error {
 		}
 		f.Close()
 	}
+
 	fatalError := make(chan error)
 	go func() {
 		for {
@@ -102,6 +161,9 @@ func run(socket, fifo string) error {
 			}()
 		}
 	}()
+	if socketRemoved == nil {
+		return <-fatalError
+	}
 	select {
 	case <-socketRemoved:
 		return nil
@@ -110,6 +172,32 @@ func run(socket, fifo string) error {
 	}
 }
 
+// acquireListener returns a listener for socket. If socket is empty, it
+// instead tries to inherit one via socket activation. owned reports whether
+// the caller owns the underlying file, i.e. whether it is responsible for
+// removing it and watching for its removal; an inherited, activated listener
+// is owned by the init system instead.
+func acquireListener(socket string) (l net.Listener, owned bool, err error) {
+	if socket == "" {
+		l, err := listenActivated()
+		if err != nil {
+			return nil, false, err
+		}
+		if l == nil {
+			return nil, false, fmt.Errorf("SOCKET required: process was not socket-activated")
+		}
+		return l, false, nil
+	}
+	if _, err := os.Stat(socket); err == nil {
+		return nil, false, fmt.Errorf("%s: socket already exists", socket)
+	}
+	l, err = net.Listen("unix", socket)
+	if err != nil {
+		return nil, false, err
+	}
+	return l, true, nil
+}
+
 // onSignals starts a goroutine that listens for sigs. When one of them arrives,
 // it runs f and then re-raises the signal to invoke the default handler.
 func onSignals(f func(), sigs ...os.Signal) {
@@ -149,10 +237,36 @@ func serve(conn io.ReadWriter) error {
 
 // handle handles a single request, req, and writes the response to w.
 func handle(w io.Writer, req string) error {
-	lang, code, ok := strings.Cut(req, ":")
+	switch req {
+	case "stats:":
+		return writeStats(w, cache)
+	case "flush:":
+		if cache != nil {
+			cache.flush()
+		}
+		_, err := io.WriteString(w, "ok")
+		return err
+	}
+	header, code, ok := strings.Cut(req, ":")
 	if !ok {
 		return fmt.Errorf("invalid request: no ':' found")
 	}
+	var key string
+	if cache != nil {
+		key = cacheKey(header, code)
+		if html, ok := cache.get(key); ok {
+			_, err := w.Write(html)
+			return err
+		}
+	}
+	lang, optStr, _ := strings.Cut(header, "?")
+	opts, err := parseOptions(optStr)
+	if err != nil {
+		return err
+	}
+	if opts.diff {
+		code, opts.diffMarkers = stripDiffMarkers(code)
+	}
 	lexer := lexers.Get(lang)
 	if lexer == nil {
 		return fmt.Errorf("%q: unsupported language", lang)
@@ -161,23 +275,29 @@ func handle(w io.Writer, req string) error {
 	if err != nil {
 		return fmt.Errorf("lexing code: %w", err)
 	}
-	writeHTML(w, iter, getClassifier(lang))
-	return nil
+	classify, err := classifiers.classifier(lang)
+	if err != nil {
+		return err
+	}
+	if cache == nil {
+		writeHTML(w, iter, classify, opts)
+		return nil
+	}
+	var buf bytes.Buffer
+	writeHTML(&buf, iter, classify, opts)
+	cache.put(key, buf.Bytes())
+	_, err = w.Write(buf.Bytes())
+	return err
 }
 
-// writeHTML reads tokens from iter and writes highlighted HTML to w.
-func writeHTML(w io.Writer, iter chroma.Iterator, classify classifier) {
-	var class string
-	flushClass := func() {
-		if class != "" {
-			fmt.Fprintf(w, "</span>")
-			class = ""
-		}
-	}
+// writeHTML reads tokens from iter and writes highlighted HTML to w,
+// decorating each line as directed by opts.
+func writeHTML(w io.Writer, iter chroma.Iterator, classify classifier, opts options) {
+	ls := newLineState(w, opts)
 	var space strings.Builder
 	flushSpace := func() {
 		if space.Len() > 0 {
-			fmt.Fprint(w, space.String())
+			ls.write(space.String())
 			space.Reset()
 		}
 	}
@@ -190,73 +310,149 @@ func writeHTML(w io.Writer, iter chroma.Iterator, classify classifier) {
 			continue
 		}
 		c := classify(prev, t, next)
-		if c != class {
-			flushClass()
+		if c != ls.class() {
 			flushSpace()
-			if c != "" {
-				fmt.Fprintf(w, `<span class="%s">`, c)
-				class = c
-			}
+			ls.setClass(c)
 		} else {
 			flushSpace()
 		}
 		value := t.Value
-		// log.Print(t)
 		value = strings.ReplaceAll(value, "&", "&amp;")
 		value = strings.ReplaceAll(value, "<", "&lt;")
-		fmt.Fprint(w, value)
+		ls.write(value)
 	}
-	flushClass()
 	flushSpace()
+	ls.close()
 }
 
-// A classifier returns the CSS class to use for token t.
-type classifier func(prev, t, next chroma.Token) string
+// lineState renders the line-oriented decorations (line numbers, hl_lines
+// marks, diff gutters, elided context) around the token classes that
+// writeHTML emits, advancing to a new line whenever it writes a "\n".
+type lineState struct {
+	w           io.Writer
+	opts        options
+	line        int // displayed line number, starting at opts.startLine
+	codeLine    int // 0-based index into opts.diffMarkers, independent of opts.startLine
+	marking     bool
+	spanOpen    bool
+	spanClass   string
+	elidedShown bool
+}
 
-// getClassifier returns the classifier to use for a given language.
-func getClassifier(lang string) classifier {
-	switch lang {
-	case "ruby":
-		return rubyTokenClass
-	default:
-		return tokenClass
+// newLineState creates a lineState and opens the first line's decorations.
+func newLineState(w io.Writer, opts options) *lineState {
+	ls := &lineState{w: w, opts: opts, line: opts.startLine}
+	if ls.line == 0 {
+		ls.line = 1
 	}
+	ls.openLine()
+	return ls
+}
+
+func (ls *lineState) class() string {
+	return ls.spanClass
 }
 
-func tokenClass(prev, t, next chroma.Token) string {
-	switch t.Type {
-	case chroma.KeywordType, chroma.NameBuiltin:
-		return "fu"
-	case chroma.KeywordPseudo, chroma.NameConstant:
-		return "cn"
+// setClass switches the CSS class applied to subsequently written text.
+func (ls *lineState) setClass(c string) {
+	if c == ls.spanClass {
+		return
 	}
-	if t.Type.InCategory(chroma.Comment) {
-		return "at"
+	if ls.spanOpen && !ls.elided() {
+		fmt.Fprint(ls.w, "</span>")
 	}
-	if t.Type.InCategory(chroma.Keyword) {
-		return "kw"
+	ls.spanClass, ls.spanOpen = c, c != ""
+	if ls.spanOpen && !ls.elided() {
+		fmt.Fprintf(ls.w, `<span class="%s">`, c)
 	}
-	if t.Type.InCategory(chroma.Literal) {
-		return "cn"
+}
+
+// write emits s under the current class, advancing the line (and its
+// decorations) at each "\n" found in s.
+func (ls *lineState) write(s string) {
+	for {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			if !ls.elided() {
+				fmt.Fprint(ls.w, s)
+			}
+			return
+		}
+		if !ls.elided() {
+			fmt.Fprint(ls.w, s[:i+1])
+		}
+		ls.advanceLine()
+		s = s[i+1:]
 	}
-	return ""
 }
 
-func rubyTokenClass(prev, t, next chroma.Token) string {
-	switch t.Type {
-	case chroma.NameConstant:
-		return ""
-	case chroma.NameVariableInstance:
-		return "fu"
-	case chroma.NameBuiltin:
-		if t.Value == "test" {
-			return "kw"
+// close closes the last open line's decorations.
+func (ls *lineState) close() {
+	ls.setClass("")
+	ls.closeLine()
+}
+
+func (ls *lineState) advanceLine() {
+	wasElided := ls.elided()
+	if !wasElided {
+		ls.closeLine()
+	}
+	ls.line++
+	ls.codeLine++
+	ls.openLine()
+}
+
+// elided reports whether the current line is too far from any hl_lines entry
+// to be worth showing, per opts.context.
+func (ls *lineState) elided() bool {
+	if ls.opts.context <= 0 || len(ls.opts.hlLines) == 0 {
+		return false
+	}
+	for d := 0; d <= ls.opts.context; d++ {
+		if ls.opts.hlLines[ls.line-d] || ls.opts.hlLines[ls.line+d] {
+			return false
+		}
+	}
+	return true
+}
+
+func (ls *lineState) openLine() {
+	if ls.elided() {
+		if !ls.elidedShown {
+			fmt.Fprint(ls.w, `<span class="elided">⋮</span>`+"\n")
+			ls.elidedShown = true
 		}
-		return ""
-	case chroma.LiteralStringSymbol:
-		if next.Value == ":" {
-			return ""
+		return
+	}
+	ls.elidedShown = false
+	if ls.opts.diff {
+		marker := byte(' ')
+		if ls.codeLine >= 0 && ls.codeLine < len(ls.opts.diffMarkers) {
+			marker = ls.opts.diffMarkers[ls.codeLine]
 		}
+		fmt.Fprintf(ls.w, `<span class="gutter %s">%c</span>`, diffGutterClass(marker), marker)
+	}
+	if ls.opts.lineNumbers {
+		fmt.Fprintf(ls.w, `<span class="ln">%d</span>`, ls.line)
+	}
+	if ls.opts.hlLines[ls.line] {
+		fmt.Fprint(ls.w, `<mark class="hl">`)
+		ls.marking = true
+	}
+	if ls.spanOpen {
+		fmt.Fprintf(ls.w, `<span class="%s">`, ls.spanClass)
 	}
-	return tokenClass(prev, t, next)
 }
+
+func (ls *lineState) closeLine() {
+	if ls.spanOpen {
+		fmt.Fprint(ls.w, "</span>")
+	}
+	if ls.marking {
+		fmt.Fprint(ls.w, "</mark>")
+		ls.marking = false
+	}
+}
+
+// A classifier returns the CSS class to use for token t.
+type classifier func(prev, t, next chroma.Token) string
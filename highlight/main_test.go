@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquireListenerNotActivated(t *testing.T) {
+	// No init system sets these, so listenActivated (on every platform it's
+	// implemented for) reports "not activated" and acquireListener must
+	// surface that as an error rather than panic on a nil listener.
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "")
+	if _, _, err := acquireListener(""); err == nil {
+		t.Error("acquireListener(\"\"): want error when not socket-activated")
+	}
+}
+
+func TestAcquireListenerSocketAlreadyExists(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sock")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := acquireListener(path); err == nil {
+		t.Error("acquireListener(existing path): want error")
+	}
+}
+
+func TestAcquireListenerOwnsFreshSocket(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sock")
+	l, owned, err := acquireListener(path)
+	if err != nil {
+		t.Fatalf("acquireListener(%s): %v", path, err)
+	}
+	defer l.Close()
+	if !owned {
+		t.Error("acquireListener: want owned=true for a path-based socket")
+	}
+}
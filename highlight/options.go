@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// options controls how writeHTML renders a single highlighting request. The
+// zero value renders exactly as if no options were given.
+type options struct {
+	lineNumbers bool
+	startLine   int
+	hlLines     map[int]bool
+	context     int
+	diff        bool
+	diffMarkers []byte // one byte ('+', '-', or ' ') per line, set when diff is true
+}
+
+// parseOptions parses the OPTS part of a "LANGUAGE ? OPTS : CODE" request
+// (the query-string-ish part after "?"), or returns the zero options if s is
+// empty. Recognized keys:
+//
+//	ln         show line numbers
+//	start=N    start numbering at N instead of 1
+//	hl=LIST    highlight the given lines, e.g. "hl=2,4-6"
+//	ctx=N      elide lines more than N away from any hl line
+//	diff       treat the first byte of each line of CODE as a '+'/'-'/' '
+//	           diff marker, rendering it as a gutter instead of code
+//
+// hl and ctx are in the same coordinate space as start: they refer to the
+// displayed line number (start+offset), not CODE's raw line position. So
+// "start=10&hl=12" highlights CODE's 3rd line, matching the line number a
+// reader would see next to it with ln. diff's markers, by contrast, are
+// always indexed by CODE's raw line position, independent of start.
+func parseOptions(s string) (options, error) {
+	var opts options
+	if s == "" {
+		return opts, nil
+	}
+	values, err := url.ParseQuery(s)
+	if err != nil {
+		return opts, fmt.Errorf("parsing options: %w", err)
+	}
+	opts.lineNumbers = values.Has("ln")
+	opts.diff = values.Has("diff")
+	if v := values.Get("start"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("start=%s: %w", v, err)
+		}
+		opts.startLine = n
+	}
+	if v := values.Get("ctx"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("ctx=%s: %w", v, err)
+		}
+		opts.context = n
+	}
+	if v := values.Get("hl"); v != "" {
+		hl, err := parseLineSet(v)
+		if err != nil {
+			return opts, fmt.Errorf("hl=%s: %w", v, err)
+		}
+		opts.hlLines = hl
+	}
+	return opts, nil
+}
+
+// parseLineSet parses a comma-separated list of line numbers and inclusive
+// ranges, e.g. "2,4-6", into the set of line numbers it names.
+func parseLineSet(s string) (map[int]bool, error) {
+	set := make(map[int]bool)
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, ok := strings.Cut(part, "-")
+		loN, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, err
+		}
+		hiN := loN
+		if ok {
+			if hiN, err = strconv.Atoi(hi); err != nil {
+				return nil, err
+			}
+		}
+		if hiN < loN {
+			return nil, fmt.Errorf("%s: range end before start", part)
+		}
+		for n := loN; n <= hiN; n++ {
+			set[n] = true
+		}
+	}
+	return set, nil
+}
+
+// stripDiffMarkers removes the leading '+'/'-'/' ' diff marker from each line
+// of code, returning the stripped code and the marker for each line.
+func stripDiffMarkers(code string) (string, []byte) {
+	lines := strings.Split(code, "\n")
+	markers := make([]byte, len(lines))
+	for i, line := range lines {
+		if line == "" {
+			markers[i] = ' '
+			continue
+		}
+		markers[i] = line[0]
+		lines[i] = line[1:]
+	}
+	return strings.Join(lines, "\n"), markers
+}
+
+// diffGutterClass returns the CSS class for a diff marker byte, following
+// chroma's own generic-inserted/generic-deleted naming.
+func diffGutterClass(marker byte) string {
+	switch marker {
+	case '+':
+		return "gi"
+	case '-':
+		return "gd"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLineSet(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[int]bool
+		wantErr bool
+	}{
+		{in: "3", want: map[int]bool{3: true}},
+		{in: "2,4-6", want: map[int]bool{2: true, 4: true, 5: true, 6: true}},
+		{in: "5-5", want: map[int]bool{5: true}},
+		{in: "", wantErr: true},
+		{in: "6-4", wantErr: true},
+		{in: "2,,4", wantErr: true},
+		{in: "x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseLineSet(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseLineSet(%q): want error, got %v", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseLineSet(%q): unexpected error: %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLineSet(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	opts, err := parseOptions("")
+	if err != nil {
+		t.Fatalf("parseOptions(\"\"): unexpected error: %v", err)
+	}
+	if opts.lineNumbers || opts.diff || opts.startLine != 0 || opts.context != 0 || opts.hlLines != nil {
+		t.Errorf("parseOptions(\"\") = %+v, want zero value", opts)
+	}
+
+	opts, err = parseOptions("ln&start=5&hl=2,4-6&diff")
+	if err != nil {
+		t.Fatalf("parseOptions: unexpected error: %v", err)
+	}
+	if !opts.lineNumbers || !opts.diff || opts.startLine != 5 {
+		t.Errorf("parseOptions: got %+v", opts)
+	}
+	want := map[int]bool{2: true, 4: true, 5: true, 6: true}
+	if !reflect.DeepEqual(opts.hlLines, want) {
+		t.Errorf("parseOptions hlLines = %v, want %v", opts.hlLines, want)
+	}
+
+	if _, err := parseOptions("start=nope"); err == nil {
+		t.Error("parseOptions(\"start=nope\"): want error")
+	}
+	if _, err := parseOptions("hl=6-4"); err == nil {
+		t.Error("parseOptions(\"hl=6-4\"): want error")
+	}
+}
+
+func TestStripDiffMarkers(t *testing.T) {
+	code, markers := stripDiffMarkers("+a\n-b\n c\n")
+	if code != "a\nb\nc\n" {
+		t.Errorf("stripDiffMarkers code = %q, want %q", code, "a\nb\nc\n")
+	}
+	want := []byte{'+', '-', ' ', ' '}
+	if !reflect.DeepEqual(markers, want) {
+		t.Errorf("stripDiffMarkers markers = %v, want %v", markers, want)
+	}
+}
+
+func TestDiffGutterClass(t *testing.T) {
+	tests := map[byte]string{'+': "gi", '-': "gd", ' ': ""}
+	for marker, want := range tests {
+		if got := diffGutterClass(marker); got != want {
+			t.Errorf("diffGutterClass(%q) = %q, want %q", marker, got, want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/chroma"
+)
+
+// tokenIter turns a fixed slice of tokens into a chroma.Iterator.
+func tokenIter(tokens []chroma.Token) chroma.Iterator {
+	i := 0
+	return func() chroma.Token {
+		if i >= len(tokens) {
+			return chroma.EOF
+		}
+		t := tokens[i]
+		i++
+		return t
+	}
+}
+
+func noClass(prev, t, next chroma.Token) string { return "" }
+
+func TestWriteHTMLLineNumbers(t *testing.T) {
+	tokens := []chroma.Token{
+		{Type: chroma.Text, Value: "one\n"},
+		{Type: chroma.Text, Value: "two\n"},
+	}
+	var buf strings.Builder
+	writeHTML(&buf, tokenIter(tokens), noClass, options{lineNumbers: true, startLine: 1})
+	want := `<span class="ln">1</span>one` + "\n" +
+		`<span class="ln">2</span>two` + "\n" +
+		`<span class="ln">3</span>`
+	if got := buf.String(); got != want {
+		t.Errorf("writeHTML() = %q, want %q", got, want)
+	}
+}
+
+func TestWriteHTMLHighlightLines(t *testing.T) {
+	tokens := []chroma.Token{
+		{Type: chroma.Text, Value: "one\n"},
+		{Type: chroma.Text, Value: "two\n"},
+	}
+	var buf strings.Builder
+	writeHTML(&buf, tokenIter(tokens), noClass, options{hlLines: map[int]bool{2: true}})
+	want := "one\n" + `<mark class="hl">two` + "\n" + `</mark>`
+	if got := buf.String(); got != want {
+		t.Errorf("writeHTML() = %q, want %q", got, want)
+	}
+}
+
+// TestWriteHTMLElision covers a multi-line token straddling the boundary
+// between shown and elided lines: the comment-like token's text is written
+// one line at a time, so elision must suppress exactly the elided lines even
+// when they're part of one long token.
+func TestWriteHTMLElision(t *testing.T) {
+	tokens := []chroma.Token{
+		{Type: chroma.Text, Value: "1\n2\n3\n4\n5\n6\n"},
+	}
+	var buf strings.Builder
+	writeHTML(&buf, tokenIter(tokens), noClass, options{
+		hlLines: map[int]bool{1: true, 6: true},
+		context: 1,
+	})
+	want := `<mark class="hl">1` + "\n" + `</mark>` +
+		"2\n" +
+		`<span class="elided">⋮</span>` + "\n" +
+		"5\n" +
+		`<mark class="hl">6` + "\n" + `</mark>`
+	if got := buf.String(); got != want {
+		t.Errorf("writeHTML() = %q, want %q", got, want)
+	}
+}
+
+// TestWriteHTMLStartWithHighlightAndDiff pins down the coordinate spaces
+// documented on parseOptions: hl/ctx address the displayed line number
+// (start+offset), while diff's markers stay indexed by CODE's raw line
+// position regardless of start. This mirrors how 869006f's diff-gutter fix
+// was protected by a regression test.
+func TestWriteHTMLStartWithHighlightAndDiff(t *testing.T) {
+	code, markers := stripDiffMarkers("+uno\n-dos\n")
+	var tokens []chroma.Token
+	for _, line := range strings.SplitAfter(code, "\n") {
+		if line == "" {
+			continue
+		}
+		tokens = append(tokens, chroma.Token{Type: chroma.Text, Value: line})
+	}
+	var buf strings.Builder
+	writeHTML(&buf, tokenIter(tokens), noClass, options{
+		lineNumbers: true,
+		startLine:   10, // displayed lines are 10 ("uno") and 11 ("dos")
+		hlLines:     map[int]bool{11: true},
+		context:     1,
+		diff:        true,
+		diffMarkers: markers,
+	})
+	// hl=11 marks the displayed 11th line ("dos", CODE's 2nd raw line), not
+	// CODE's own 11th line; diff's '-' gutter still lands on that same raw
+	// line regardless of the start=10 offset.
+	want := `<span class="gutter gi">+</span><span class="ln">10</span>` + "uno\n" +
+		`<span class="gutter gd">-</span><span class="ln">11</span><mark class="hl">` + "dos\n" + `</mark>` +
+		`<span class="gutter "> </span><span class="ln">12</span>`
+	if got := buf.String(); got != want {
+		t.Errorf("writeHTML() = %q, want %q", got, want)
+	}
+}
+
+func TestWriteHTMLDiff(t *testing.T) {
+	code, markers := stripDiffMarkers("+added\n-removed\n kept\n")
+	var tokens []chroma.Token
+	for _, line := range strings.SplitAfter(code, "\n") {
+		if line == "" {
+			continue
+		}
+		tokens = append(tokens, chroma.Token{Type: chroma.Text, Value: line})
+	}
+	var buf strings.Builder
+	writeHTML(&buf, tokenIter(tokens), noClass, options{diff: true, diffMarkers: markers})
+	want := `<span class="gutter gi">+</span>added` + "\n" +
+		`<span class="gutter gd">-</span>removed` + "\n" +
+		`<span class="gutter "> </span>kept` + "\n" +
+		`<span class="gutter "> </span>`
+	if got := buf.String(); got != want {
+		t.Errorf("writeHTML() = %q, want %q", got, want)
+	}
+}